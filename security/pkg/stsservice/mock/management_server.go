@@ -0,0 +1,226 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+
+	"istio.io/pkg/log"
+)
+
+var mockLog = log.RegisterScope("mock", "STS test harness xDS mock debugging", 0)
+
+// ManagementServer abstracts the xDS management server the test harness's
+// simulated Envoy proxy connects to. StaticManagementServer pushes a single
+// snapshot at startup and never mutates it; SnapshotManagementServer lets a
+// single test run mutate LDS/CDS/RDS resources without restarting Envoy.
+type ManagementServer interface {
+	// Start brings the management server up and begins serving conf's
+	// listen address.
+	Start(conf XDSConf, secure bool) (*grpc.Server, error)
+	// Stop tears down the management server.
+	Stop()
+}
+
+// callbacksBase is a no-op implementation of serverv3.Callbacks, embedded by
+// both ManagementServer implementations so each only has to override the
+// handful of methods it actually cares about.
+type callbacksBase struct{}
+
+func (callbacksBase) OnStreamOpen(_ context.Context, _ int64, _ string) error { return nil }
+func (callbacksBase) OnStreamClosed(_ int64)                                 {}
+func (callbacksBase) OnStreamRequest(_ int64, _ *discovery.DiscoveryRequest) error {
+	return nil
+}
+func (callbacksBase) OnStreamResponse(_ context.Context, _ int64, _ *discovery.DiscoveryRequest, _ *discovery.DiscoveryResponse) {
+}
+func (callbacksBase) OnFetchRequest(_ context.Context, _ *discovery.DiscoveryRequest) error {
+	return nil
+}
+func (callbacksBase) OnFetchResponse(_ *discovery.DiscoveryRequest, _ *discovery.DiscoveryResponse) {
+}
+func (callbacksBase) OnDeltaStreamOpen(_ context.Context, _ int64, _ string) error { return nil }
+func (callbacksBase) OnDeltaStreamClosed(_ int64)                                 {}
+func (callbacksBase) OnStreamDeltaRequest(_ int64, _ *discovery.DeltaDiscoveryRequest) error {
+	return nil
+}
+func (callbacksBase) OnStreamDeltaResponse(_ int64, _ *discovery.DeltaDiscoveryRequest, _ *discovery.DeltaDiscoveryResponse) {
+}
+
+// StaticManagementServer serves a single snapshot, built from Resources, for
+// the lifetime of the test. It satisfies ManagementServer.
+type StaticManagementServer struct {
+	callbacksBase
+
+	// NodeID identifies the simulated proxy in the snapshot cache.
+	NodeID string
+	// Resources is the full set of LDS/CDS/RDS/SDS resources to serve.
+	Resources []cachev3.Resource
+
+	cache      cachev3.SnapshotCache
+	grpcServer *grpc.Server
+}
+
+// Start implements ManagementServer: it builds the one-time snapshot from
+// s.Resources, registers the ADS server backed by it, and only then starts
+// serving conf's listen address.
+func (s *StaticManagementServer) Start(conf XDSConf, secure bool) (*grpc.Server, error) {
+	s.cache = cachev3.NewSnapshotCache(true, cachev3.IDHash{}, mockLog)
+	snapshot, err := cachev3.NewSnapshot("static", groupByTypeURL(s.Resources))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build static snapshot: %v", err)
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return nil, fmt.Errorf("static snapshot is inconsistent: %v", err)
+	}
+	if err := s.cache.SetSnapshot(s.NodeID, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to set static snapshot: %v", err)
+	}
+
+	xdsServer := serverv3.NewServer(context.Background(), s.cache, s)
+	grpcServer, err := startXDSServer(conf, secure, func(gs *grpc.Server) {
+		discovery.RegisterAggregatedDiscoveryServiceServer(gs, xdsServer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.grpcServer = grpcServer
+	return grpcServer, nil
+}
+
+// Stop implements ManagementServer.
+func (s *StaticManagementServer) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// SnapshotManagementServer is a ManagementServer backed by go-control-plane's
+// snapshot cache, so a test can push new LDS/CDS/RDS versions to a running
+// Envoy (add/remove listeners, flip cluster TLS, rotate SDS secrets) and
+// wait for the proxy to ACK them, all within a single test run.
+type SnapshotManagementServer struct {
+	callbacksBase
+
+	// NodeID identifies the simulated proxy in the snapshot cache, so
+	// multiple simulated proxies can share one management server by each
+	// connecting with a distinct NodeID.
+	NodeID string
+
+	cache      cachev3.SnapshotCache
+	grpcServer *grpc.Server
+
+	mu      sync.Mutex
+	acked   map[string]string // typeURL -> last acked version
+	ackCond *sync.Cond
+}
+
+// NewSnapshotManagementServer creates a SnapshotManagementServer for the
+// simulated proxy identified by nodeID.
+func NewSnapshotManagementServer(nodeID string) *SnapshotManagementServer {
+	s := &SnapshotManagementServer{
+		NodeID: nodeID,
+		cache:  cachev3.NewSnapshotCache(true, cachev3.IDHash{}, mockLog),
+		acked:  make(map[string]string),
+	}
+	s.ackCond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Start implements ManagementServer, registering the ADS server backed by
+// the snapshot cache before conf's listen address starts serving.
+func (s *SnapshotManagementServer) Start(conf XDSConf, secure bool) (*grpc.Server, error) {
+	xdsServer := serverv3.NewServer(context.Background(), s.cache, s)
+	grpcServer, err := startXDSServer(conf, secure, func(gs *grpc.Server) {
+		discovery.RegisterAggregatedDiscoveryServiceServer(gs, xdsServer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.grpcServer = grpcServer
+	return grpcServer, nil
+}
+
+// Stop implements ManagementServer.
+func (s *SnapshotManagementServer) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// UpdateSnapshot pushes a new, fully-specified snapshot at version to the
+// simulated proxy. resources is a flat list of typed go-control-plane
+// resources (listeners, clusters, routes, secrets); it is the caller's
+// responsibility to include every resource that should exist after the
+// update, as each call replaces the prior snapshot wholesale.
+func (s *SnapshotManagementServer) UpdateSnapshot(version string, resources ...cachev3.Resource) error {
+	snapshot, err := cachev3.NewSnapshot(version, groupByTypeURL(resources))
+	if err != nil {
+		return fmt.Errorf("failed to build snapshot version %s: %v", version, err)
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return fmt.Errorf("snapshot version %s is inconsistent: %v", version, err)
+	}
+	return s.cache.SetSnapshot(s.NodeID, snapshot)
+}
+
+// WaitForAck blocks until the proxy's own DiscoveryRequest acknowledges
+// version for typeURL, or timeout elapses.
+func (s *SnapshotManagementServer) WaitForAck(typeURL, version string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.acked[typeURL] != version {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("timed out waiting for %s to ack version %s (last acked: %s)", typeURL, version, s.acked[typeURL])
+		}
+		timer := time.AfterFunc(remaining, s.ackCond.Broadcast)
+		s.ackCond.Wait()
+		timer.Stop()
+	}
+	return nil
+}
+
+// OnStreamRequest overrides callbacksBase to observe ACKs. A genuine ACK is
+// the proxy's follow-up DiscoveryRequest carrying back the version it was
+// sent with no error, as opposed to the initial, version-less request that
+// opens a resource type's subscription or a NACK carrying ErrorDetail.
+func (s *SnapshotManagementServer) OnStreamRequest(_ int64, req *discovery.DiscoveryRequest) error {
+	if req.VersionInfo == "" || req.ErrorDetail != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked[req.TypeUrl] = req.VersionInfo
+	s.ackCond.Broadcast()
+	return nil
+}
+
+// groupByTypeURL buckets resources by their protobuf Any type URL, which is
+// the key cachev3.NewSnapshot expects its resource map indexed by.
+func groupByTypeURL(resources []cachev3.Resource) map[string][]cachev3.Resource {
+	grouped := make(map[string][]cachev3.Resource)
+	for _, r := range resources {
+		typeURL := "type.googleapis.com/" + string(r.ProtoReflect().Descriptor().FullName())
+		grouped[typeURL] = append(grouped[typeURL], r)
+	}
+	return grouped
+}
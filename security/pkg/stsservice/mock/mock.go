@@ -0,0 +1,77 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mock provides a fake xDS management server for the STS test
+// harness's simulated Envoy proxy to connect to.
+package mock
+
+import (
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// XDSConf configures the listen address and, optionally, TLS serving
+// credentials for a management server.
+type XDSConf struct {
+	Port     int
+	CertFile string
+	KeyFile  string
+}
+
+// XDSCallbacks records the access token the simulated proxy is expected to
+// present. The management servers in this package do not check it.
+type XDSCallbacks struct {
+	expectedToken string
+}
+
+// NewXDSCallbacks creates callbacks that expect the proxy to authenticate
+// with expectedToken.
+func NewXDSCallbacks(expectedToken string) *XDSCallbacks {
+	return &XDSCallbacks{expectedToken: expectedToken}
+}
+
+// ExpectedToken returns the access token the fake auth backend will mint.
+func (c *XDSCallbacks) ExpectedToken() string {
+	return c.expectedToken
+}
+
+// startXDSServer builds the grpc.Server for a management server, lets
+// register attach the concrete discovery.ServiceServer implementation, and
+// only then starts serving conf's listen address. register must be called
+// before Serve: grpc-go requires every service to be registered ahead of
+// time and otherwise aborts the process.
+func startXDSServer(conf XDSConf, secure bool, register func(*grpc.Server)) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if secure {
+		creds, err := credentials.NewServerTLSFromFile(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load xDS server TLS credentials: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	grpcServer := grpc.NewServer(opts...)
+	register(grpcServer)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", conf.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on xDS port %d: %v", conf.Port, err)
+	}
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	return grpcServer, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/url"
+	"strings"
+)
+
+// isAudienceAllowed reports whether target is permitted by the server's
+// AllowedAudienceDomains. An empty target (the parameter was omitted from
+// the request) and an empty whitelist both trivially allow.
+func (s *Server) isAudienceAllowed(target string) bool {
+	if target == "" || len(s.config.AllowedAudienceDomains) == 0 {
+		return true
+	}
+	host := hostOf(target)
+	for _, domain := range s.config.AllowedAudienceDomains {
+		if domainMatches(domain, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf extracts the hostname from target, which may be a bare hostname
+// (e.g. from the "audience" parameter) or a full URL (e.g. from
+// "resource"). If target cannot be parsed as a URL, it is treated as a bare
+// hostname.
+func hostOf(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return target
+}
+
+// domainMatches reports whether host is permitted by domain. A domain
+// without a leading dot must match host exactly; a domain with a leading
+// dot (e.g. ".example.com") matches any strict subdomain of the part after
+// the dot (e.g. "foo.example.com") but not the bare domain itself or an
+// unrelated domain like "evil.com".
+func domainMatches(domain, host string) bool {
+	if strings.HasPrefix(domain, ".") {
+		suffix := domain // keep the leading dot so "evil.com" can't match ".example.com" via a naive suffix check
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return domain == host
+}
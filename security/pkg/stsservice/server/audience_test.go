@@ -0,0 +1,51 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "testing"
+
+func TestIsAudienceAllowed(t *testing.T) {
+	s := &Server{config: Config{AllowedAudienceDomains: []string{"backend.example.com", ".example.com"}}}
+
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{"empty target always allowed", "", true},
+		{"exact hostname match", "backend.example.com", true},
+		{"exact hostname match as URL", "https://backend.example.com/token", true},
+		{"subdomain matches leading-dot entry", "foo.example.com", true},
+		{"nested subdomain matches leading-dot entry", "a.b.example.com", true},
+		{"bare domain does not match leading-dot entry", "example.com", false},
+		{"unrelated domain rejected", "evil.com", false},
+		{"lookalike suffix rejected", "notexample.com", false},
+		{"suffix-matching evil domain rejected", "evilexample.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.isAudienceAllowed(tt.target); got != tt.want {
+				t.Errorf("isAudienceAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAudienceAllowedNoWhitelist(t *testing.T) {
+	s := &Server{config: Config{}}
+	if !s.isAudienceAllowed("anything.example.com") {
+		t.Error("expected empty whitelist to allow any domain")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/stsservice"
+)
+
+// Cache stores STS responses keyed by the request's (subject token,
+// audience, resource, scope) fields, so that a caller asking for the same
+// token twice within its TTL doesn't trigger a redundant upstream exchange.
+// Server uses this interface so the default chunked cache can be swapped for
+// an LRU or a distributed cache in deployments that need one.
+type Cache interface {
+	Get(params stsservice.StsRequestParameters) ([]byte, bool)
+	Put(params stsservice.StsRequestParameters, response []byte)
+
+	// Metrics returns the current hit/miss/chunk counters, for exposition.
+	Metrics() CacheMetrics
+}
+
+// CacheMetrics is a point-in-time snapshot of cache activity.
+type CacheMetrics struct {
+	Hits       int64
+	Misses     int64
+	ChunkCount int64
+}
+
+// CacheOptions configures ChunkedCache.
+type CacheOptions struct {
+	// TTL is how long a cached response remains valid.
+	TTL time.Duration
+	// NumShards is the number of independent shards a response is split
+	// across once it crosses ChunkThresholdBytes. Sharding keeps any one
+	// map entry small, which matters for cache implementations (or
+	// sidecars) with a practical single-item size limit.
+	NumShards int
+	// ChunkThresholdBytes is the serialized response size above which
+	// chunking activates. Responses at or below this size are stored as a
+	// single shard.
+	ChunkThresholdBytes int
+}
+
+// DefaultCacheOptions returns the options used by production STS servers.
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		TTL:                 10 * time.Minute,
+		NumShards:           4,
+		ChunkThresholdBytes: 4096,
+	}
+}
+
+type cacheEntry struct {
+	chunks [][]byte
+	expiry time.Time
+}
+
+// ChunkedCache is the default Cache implementation. Responses larger than
+// ChunkThresholdBytes are split into up to NumShards chunks and reassembled
+// on Get, so no single stored value exceeds response-size/NumShards bytes,
+// rounded up.
+type ChunkedCache struct {
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	metrics CacheMetrics
+}
+
+// NewChunkedCache creates a ChunkedCache configured by opts.
+func NewChunkedCache(opts CacheOptions) *ChunkedCache {
+	if opts.NumShards <= 0 {
+		opts.NumShards = 1
+	}
+	return &ChunkedCache{
+		opts:    opts,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// Get returns the cached, reassembled response for params if present and
+// unexpired.
+func (c *ChunkedCache) Get(params stsservice.StsRequestParameters) ([]byte, bool) {
+	key := cacheKey(params)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiry) {
+		if ok {
+			delete(c.entries, key)
+		}
+		c.metrics.Misses++
+		return nil, false
+	}
+	c.metrics.Hits++
+	return reassemble(entry.chunks), true
+}
+
+// Put stores response under the key derived from params, splitting it
+// across shards if it exceeds ChunkThresholdBytes.
+func (c *ChunkedCache) Put(params stsservice.StsRequestParameters, response []byte) {
+	key := cacheKey(params)
+	chunks := chunk(response, c.opts.NumShards, c.opts.ChunkThresholdBytes)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{
+		chunks: chunks,
+		expiry: time.Now().Add(c.opts.TTL),
+	}
+	c.metrics.ChunkCount += int64(len(chunks))
+}
+
+// Metrics returns the current hit/miss/chunk counters.
+func (c *ChunkedCache) Metrics() CacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.metrics
+}
+
+// cacheKey derives a stable cache key from the subject token hash, audience,
+// resource, and scope, per the (subject_token_hash, audience, resource,
+// scope) scheme. resource must be included alongside audience: two exchanges
+// that differ only by resource target distinct credentials and must not
+// collide in the cache. The subject token itself is never stored as the key
+// to avoid keeping a long-lived credential resident in the cache's key
+// space.
+func cacheKey(params stsservice.StsRequestParameters) string {
+	sum := sha256.Sum256([]byte(params.SubjectToken))
+	return hex.EncodeToString(sum[:]) + "|" + params.Audience + "|" + params.Resource + "|" + params.Scope
+}
+
+// chunk splits data into at most numShards pieces once len(data) exceeds
+// threshold; below threshold, it is returned as a single chunk.
+func chunk(data []byte, numShards, threshold int) [][]byte {
+	if len(data) <= threshold || numShards <= 1 {
+		return [][]byte{data}
+	}
+	chunkSize := (len(data) + numShards - 1) / numShards
+	chunks := make([][]byte, 0, numShards)
+	for i := 0; i < len(data); i += chunkSize {
+		end := i + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[i:end])
+	}
+	return chunks
+}
+
+// reassemble concatenates chunks back into the original response.
+func reassemble(chunks [][]byte) []byte {
+	if len(chunks) == 1 {
+		return chunks[0]
+	}
+	total := 0
+	for _, c := range chunks {
+		total += len(c)
+	}
+	out := make([]byte, 0, total)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return out
+}
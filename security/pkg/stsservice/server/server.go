@@ -0,0 +1,235 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server implements the STS server: an HTTP endpoint that performs
+// RFC 8693 OAuth 2.0 token exchange, delegating the actual credential
+// minting to a pluggable token manager.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"istio.io/pkg/log"
+
+	"istio.io/istio/security/pkg/stsservice"
+)
+
+var serverLog = log.RegisterScope("sts", "STS server debugging", 0)
+
+const (
+	// TokenPath is the path the STS server listens on for token exchange requests.
+	TokenPath = "/token"
+	// TokenExchangeGrantType is the only grant type this server supports.
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// SubjectTokenType is the only subject token type this server supports.
+	SubjectTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	// URLEncodedForm is the required Content-Type for STS requests.
+	URLEncodedForm = "application/x-www-form-urlencoded"
+
+	readHeaderTimeout = 5 * time.Second
+)
+
+// TokenManager exchanges an StsRequestParameters for minted credentials. It
+// is implemented by tokenmanager.TokenManager. ctx carries the request's
+// trace span, so plugins can propagate it to upstream HTTP calls.
+type TokenManager interface {
+	GenerateToken(ctx context.Context, parameters stsservice.StsRequestParameters) ([]byte, error)
+	DumpTokenStatus() ([]byte, error)
+}
+
+// Config holds the STS server's listen address and optional security
+// hardening knobs.
+type Config struct {
+	LocalHostAddr string
+	LocalPort     int
+
+	// CacheOptions overrides the STS response cache's defaults, e.g. to
+	// force a small ChunkThresholdBytes in tests. The zero value uses
+	// DefaultCacheOptions.
+	CacheOptions CacheOptions
+
+	// AllowedAudienceDomains whitelists the domains an audience or resource
+	// parameter may target. An entry matches either an exact hostname
+	// ("example.com") or, with a leading dot (".example.com"), any of its
+	// subdomains. A nil/empty list allows any domain, preserving prior
+	// behavior.
+	AllowedAudienceDomains []string
+
+	// Tracing configures Zipkin export of the exchange flow's spans. The
+	// zero value disables tracing.
+	Tracing TracingConfig
+}
+
+// Server is the STS server.
+type Server struct {
+	config Config
+	tm     TokenManager
+	cache  Cache
+
+	httpServer      *http.Server
+	listener        net.Listener
+	shutdownTracing func()
+}
+
+// NewServer creates and starts an STS server listening on config's address.
+func NewServer(config Config, tm TokenManager) (*Server, error) {
+	addr := fmt.Sprintf("%s:%d", config.LocalHostAddr, config.LocalPort)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	cacheOpts := config.CacheOptions
+	if cacheOpts.NumShards == 0 && cacheOpts.TTL == 0 && cacheOpts.ChunkThresholdBytes == 0 {
+		cacheOpts = DefaultCacheOptions()
+	}
+	shutdownTracing, err := ConfigureTracing("sts-server", config.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tracing: %v", err)
+	}
+	s := &Server{
+		config:          config,
+		tm:              tm,
+		cache:           NewChunkedCache(cacheOpts),
+		listener:        listener,
+		shutdownTracing: shutdownTracing,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(TokenPath, s.ServeStsRequests)
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			serverLog.Errorf("STS server stopped serving: %v", err)
+		}
+	}()
+	return s, nil
+}
+
+// Cache exposes the server's response cache, primarily so tests can inspect
+// hit/miss/chunk metrics.
+func (s *Server) Cache() Cache {
+	return s.cache
+}
+
+// Stop gracefully shuts down the STS server.
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		serverLog.Errorf("failed to gracefully shut down STS server: %v", err)
+	}
+	s.shutdownTracing()
+}
+
+// ServeStsRequests handles an incoming STS token exchange request. The
+// request's span tree covers request parse/validate, token generation
+// (which, inside the token manager plugin, further breaks down into the
+// federated-token and access-token HTTP calls), and response serialization,
+// continuing the caller's trace if B3 or W3C headers are present.
+func (s *Server) ServeStsRequests(w http.ResponseWriter, req *http.Request) {
+	ctx, rootSpan := extractSpanContext(req, "sts.exchange")
+	defer rootSpan.End()
+
+	params, ok := s.parseAndValidate(ctx, w, req)
+	if !ok {
+		return
+	}
+
+	if cached, ok := s.cache.Get(params); ok {
+		s.writeResponse(ctx, w, cached)
+		return
+	}
+
+	genCtx, genSpan := trace.StartSpan(ctx, "sts.token_manager.generate_token")
+	tokenResp, err := s.tm.GenerateToken(genCtx, params)
+	genSpan.End()
+	if err != nil {
+		serverLog.Errorf("token generation failed: %v", err)
+		s.writeError(ctx, w, http.StatusInternalServerError, stsservice.ErrorServerError, "failed to generate token")
+		return
+	}
+	s.cache.Put(params, tokenResp)
+	s.writeResponse(ctx, w, tokenResp)
+}
+
+// parseAndValidate parses req's form body into StsRequestParameters and
+// checks the grant type and audience whitelist, writing an error response
+// and returning ok=false on any failure.
+func (s *Server) parseAndValidate(ctx context.Context, w http.ResponseWriter, req *http.Request) (stsservice.StsRequestParameters, bool) {
+	_, span := trace.StartSpan(ctx, "sts.parse_validate")
+	defer span.End()
+
+	if req.Method != http.MethodPost {
+		s.writeError(ctx, w, http.StatusMethodNotAllowed, stsservice.ErrorInvalidRequest, "only POST is supported")
+		return stsservice.StsRequestParameters{}, false
+	}
+	if req.Header.Get("Content-Type") != URLEncodedForm {
+		s.writeError(ctx, w, http.StatusBadRequest, stsservice.ErrorInvalidRequest, "unsupported content type")
+		return stsservice.StsRequestParameters{}, false
+	}
+	if err := req.ParseForm(); err != nil {
+		s.writeError(ctx, w, http.StatusBadRequest, stsservice.ErrorInvalidRequest, "failed to parse request")
+		return stsservice.StsRequestParameters{}, false
+	}
+
+	params := stsservice.StsRequestParameters{
+		GrantType:          req.Form.Get("grant_type"),
+		Resource:           req.Form.Get("resource"),
+		Audience:           req.Form.Get("audience"),
+		Scope:              req.Form.Get("scope"),
+		RequestedTokenType: req.Form.Get("requested_token_type"),
+		SubjectToken:       req.Form.Get("subject_token"),
+		SubjectTokenType:   req.Form.Get("subject_token_type"),
+		ActorToken:         req.Form.Get("actor_token"),
+		ActorTokenType:     req.Form.Get("actor_token_type"),
+	}
+	if params.GrantType != TokenExchangeGrantType {
+		s.writeError(ctx, w, http.StatusBadRequest, stsservice.ErrorInvalidRequest, "unsupported grant_type")
+		return stsservice.StsRequestParameters{}, false
+	}
+	if !s.isAudienceAllowed(params.Audience) || !s.isAudienceAllowed(params.Resource) {
+		s.writeError(ctx, w, http.StatusBadRequest, stsservice.ErrorInvalidTarget,
+			"audience or resource is not in the allowed domain list")
+		return stsservice.StsRequestParameters{}, false
+	}
+	return params, true
+}
+
+func (s *Server) writeResponse(ctx context.Context, w http.ResponseWriter, body []byte) {
+	_, span := trace.StartSpan(ctx, "sts.response_serialize")
+	defer span.End()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func (s *Server) writeError(ctx context.Context, w http.ResponseWriter, status int, code, description string) {
+	_, span := trace.StartSpan(ctx, "sts.response_serialize")
+	defer span.End()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(stsservice.StsErrorParameters{Error: code, ErrorDescription: description})
+}
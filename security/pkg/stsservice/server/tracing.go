@@ -0,0 +1,70 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	zipkinExporter "contrib.go.opencensus.io/exporter/zipkin"
+	openzipkin "github.com/openzipkin/zipkin-go"
+	zipkinHTTPReporter "github.com/openzipkin/zipkin-go/reporter/http"
+	"go.opencensus.io/trace"
+
+	"istio.io/istio/security/pkg/stsservice"
+)
+
+// TracingConfig enables exporting the STS exchange flow's spans to a Zipkin
+// collector so multi-hop exchange latency can be broken down hop-by-hop.
+type TracingConfig struct {
+	// ZipkinCollectorURL is the Zipkin HTTP collector endpoint, e.g.
+	// "http://zipkin:9411/api/v2/spans". Tracing is disabled when empty.
+	ZipkinCollectorURL string
+	// SampleRate is the fraction, in [0,1], of requests to trace.
+	SampleRate float64
+}
+
+// ConfigureTracing wires an OpenCensus Zipkin exporter and sampler for
+// serviceName per cfg. It returns a function to flush and unregister the
+// exporter on shutdown. A zero value TracingConfig disables tracing and
+// returns a no-op shutdown function.
+func ConfigureTracing(serviceName string, cfg TracingConfig) (func(), error) {
+	if cfg.ZipkinCollectorURL == "" {
+		return func() {}, nil
+	}
+	localEndpoint, err := openzipkin.NewEndpoint(serviceName, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Zipkin local endpoint: %v", err)
+	}
+	reporter := zipkinHTTPReporter.NewReporter(cfg.ZipkinCollectorURL)
+	exporter := zipkinExporter.NewExporter(reporter, localEndpoint)
+	trace.RegisterExporter(exporter)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(cfg.SampleRate)})
+
+	return func() {
+		trace.UnregisterExporter(exporter)
+		_ = reporter.Close()
+	}, nil
+}
+
+// extractSpanContext starts the root span for an incoming STS request,
+// continuing the caller's trace if B3 or W3C trace headers are present.
+func extractSpanContext(req *http.Request, name string) (context.Context, *trace.Span) {
+	if sc, ok := stsservice.ExtractSpanContext(req); ok {
+		return trace.StartSpanWithRemoteParent(req.Context(), name, sc)
+	}
+	return trace.StartSpan(req.Context(), name)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	xdsService "istio.io/istio/security/pkg/stsservice/mock"
+)
+
+// TestAudienceWhitelistMatrix drives SetUpTestWithAllowedAudienceDomains
+// end-to-end through genStsReqForAudience, covering an allowed exact
+// hostname, an allowed subdomain under a leading-dot entry, the subdomain
+// boundary (the bare domain itself must not match its own leading-dot
+// entry), and a disallowed, unrelated domain.
+func TestAudienceWhitelistMatrix(t *testing.T) {
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTestWithAllowedAudienceDomains(t, cb, 1650, []string{"trusted.com", ".example.com"})
+	defer env.TearDown()
+
+	tests := []struct {
+		name    string
+		target  string
+		allowed bool
+	}{
+		{"exact hostname allowed", "trusted.com", true},
+		{"subdomain allowed under leading-dot entry", "foo.example.com", true},
+		{"bare domain rejected by leading-dot entry", "example.com", false},
+		{"unrelated domain rejected", "evil.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := env.SendStsReqForAudience(t, tt.target, "https://"+tt.target)
+			wantStatus := http.StatusBadRequest
+			if tt.allowed {
+				wantStatus = http.StatusOK
+			}
+			if resp.StatusCode != wantStatus {
+				t.Errorf("audience/resource %q: got status %d, want %d", tt.target, resp.StatusCode, wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	xdsService "istio.io/istio/security/pkg/stsservice/mock"
+)
+
+// TestChunkedCacheForcedChunking forces the STS server's response cache to
+// chunk at a threshold well below a real response's size, and verifies both
+// that requests served through the chunked cache still succeed and that the
+// cache metrics actually recorded the chunking.
+func TestChunkedCacheForcedChunking(t *testing.T) {
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTestWithSmallCacheChunks(t, cb, 1620, 16, 4)
+	defer env.TearDown()
+
+	resp := env.SendStsReqForAudience(t, "audience", "https://backend.example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a successful token exchange, got status %d", resp.StatusCode)
+	}
+	// A second request for the same parameters should be served from cache.
+	resp = env.SendStsReqForAudience(t, "audience", "https://backend.example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a successful cached token exchange, got status %d", resp.StatusCode)
+	}
+
+	metrics := env.CacheMetrics()
+	if metrics.ChunkCount <= 1 {
+		t.Errorf("expected the forced-small chunk threshold to split the response into more than one chunk, got %d", metrics.ChunkCount)
+	}
+	if metrics.Hits == 0 {
+		t.Errorf("expected the second identical request to hit the cache")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// ForwardProxy is a minimal HTTP CONNECT proxy used to verify that the STS
+// server's outbound calls to the token backend can be routed through an
+// egress proxy. When BasicAuthUser/Pass are set, CONNECT requests without a
+// matching Proxy-Authorization header are rejected.
+type ForwardProxy struct {
+	Addr string
+
+	BasicAuthUser string
+	BasicAuthPass string
+
+	// ConnectCount is incremented for every successful CONNECT tunnel, so
+	// tests can assert that STS traffic actually went through the proxy.
+	ConnectCount int
+
+	listener net.Listener
+}
+
+// StartForwardProxy starts a ForwardProxy listening on 127.0.0.1:port.
+func StartForwardProxy(t *testing.T, port int, basicAuthUser, basicAuthPass string) *ForwardProxy {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("failed to start forward proxy: %v", err)
+	}
+	p := &ForwardProxy{
+		Addr:          listener.Addr().String(),
+		BasicAuthUser: basicAuthUser,
+		BasicAuthPass: basicAuthPass,
+		listener:      listener,
+	}
+	go p.serve()
+	return p
+}
+
+// Stop closes the proxy's listener.
+func (p *ForwardProxy) Stop() error {
+	return p.listener.Close()
+}
+
+func (p *ForwardProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go p.handleConn(conn)
+	}
+}
+
+func (p *ForwardProxy) handleConn(clientConn net.Conn) {
+	defer clientConn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(clientConn))
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		clientConn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+		return
+	}
+	if p.BasicAuthUser != "" {
+		user, pass, ok := proxyBasicAuth(req)
+		if !ok || user != p.BasicAuthUser || pass != p.BasicAuthPass {
+			clientConn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+	}
+
+	upstreamConn, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		return
+	}
+	defer upstreamConn.Close()
+
+	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	p.ConnectCount++
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstreamConn, clientConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(clientConn, upstreamConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// proxyBasicAuth parses basic auth credentials off req's Proxy-Authorization
+// header. Go's http.Request.BasicAuth only reads Authorization, which is the
+// header used for auth against the destination, not the proxy hop; a CONNECT
+// request's proxy credentials are always sent as Proxy-Authorization.
+func proxyBasicAuth(req *http.Request) (user, pass string, ok bool) {
+	const prefix = "Basic "
+	auth := req.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
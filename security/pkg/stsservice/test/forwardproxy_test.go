@@ -0,0 +1,49 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"testing"
+
+	xdsService "istio.io/istio/security/pkg/stsservice/mock"
+)
+
+// TestStsThroughForwardProxy verifies that the STS server's outbound calls
+// to the token backend actually cross the forward proxy's CONNECT tunnel,
+// rather than reaching the backend some other way (Go's http.Client issues
+// no CONNECT for a plain http:// destination, nor for any request its
+// proxy selection sends direct).
+func TestStsThroughForwardProxy(t *testing.T) {
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTestWithProxy(t, cb, 1610, "", "")
+	defer env.TearDown()
+
+	if env.ForwardProxy.ConnectCount == 0 {
+		t.Errorf("expected at least one CONNECT tunnel through the forward proxy, got %d", env.ForwardProxy.ConnectCount)
+	}
+}
+
+// TestStsThroughForwardProxyWithBasicAuth is like TestStsThroughForwardProxy,
+// except the forward proxy requires basic auth on the CONNECT hop, so it
+// exercises the Proxy-Authorization header the request requires.
+func TestStsThroughForwardProxyWithBasicAuth(t *testing.T) {
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTestWithProxy(t, cb, 1611, "proxy-user", "proxy-pass")
+	defer env.TearDown()
+
+	if env.ForwardProxy.ConnectCount == 0 {
+		t.Errorf("expected at least one authenticated CONNECT tunnel through the forward proxy, got %d", env.ForwardProxy.ConnectCount)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestOIDCRefreshToken drives the oidc plugin end-to-end against a fake
+// issuer: the first exchange should use the token-exchange grant, and once
+// the resulting ID token's short lifetime has elapsed, the second should use
+// the refresh_token grant instead of re-running the full exchange.
+func TestOIDCRefreshToken(t *testing.T) {
+	env := SetUpOIDCTest(t, 1600)
+	defer env.TearDown()
+
+	resp := env.SendStsReq(t)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("initial token exchange failed with status %d", resp.StatusCode)
+	}
+
+	// The fake issuer's ExpiresIn is 1s and RefreshSkew is 2s, so the cached
+	// token is already due for a refresh well before this sleep completes.
+	time.Sleep(2 * time.Second)
+
+	resp = env.SendStsReq(t)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refreshed token exchange failed with status %d", resp.StatusCode)
+	}
+
+	grants := env.IssuerServer.Grants()
+	if len(grants) < 2 || grants[0] != "urn:ietf:params:oauth:grant-type:token-exchange" || grants[1] != "refresh_token" {
+		t.Errorf("expected grants [token-exchange, refresh_token], got %v", grants)
+	}
+}
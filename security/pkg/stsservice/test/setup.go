@@ -16,6 +16,7 @@ package test
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -30,6 +31,8 @@ import (
 
 	"google.golang.org/grpc"
 
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+
 	"istio.io/istio/security/pkg/stsservice/tokenmanager/google"
 
 	proxyEnv "istio.io/istio/mixer/test/client/env"
@@ -38,6 +41,8 @@ import (
 	stsServer "istio.io/istio/security/pkg/stsservice/server"
 	"istio.io/istio/security/pkg/stsservice/tokenmanager"
 	tokenBackend "istio.io/istio/security/pkg/stsservice/tokenmanager/google/mock"
+	"istio.io/istio/security/pkg/stsservice/tokenmanager/oidc"
+	oidcBackend "istio.io/istio/security/pkg/stsservice/tokenmanager/oidc/mock"
 )
 
 const (
@@ -51,12 +56,50 @@ type Env struct {
 	ProxySetUp *proxyEnv.TestSetup
 	AuthServer *tokenBackend.AuthorizationServer
 
+	// ForwardProxy is non-nil when the Env was created with
+	// SetUpTestWithProxy, and routes the STS server's outbound calls to
+	// AuthServer through a CONNECT tunnel, authenticated with Basic auth when
+	// SetUpTestWithProxy was given a basicAuthUser.
+	ForwardProxy *ForwardProxy
+
+	// NodeID identifies the simulated proxy to the management server. It is
+	// only meaningful when managementServer is a *mock.SnapshotManagementServer.
+	NodeID string
+
 	stsServer         *stsServer.Server
 	xDSServer         *grpc.Server
+	managementServer  xdsService.ManagementServer
 	ProxyListenerPort int
 	initialToken      string // initial token is sent to STS server for token exchange
 }
 
+// UpdateSnapshot pushes a new LDS/CDS/RDS/SDS snapshot at version to the
+// simulated proxy. It requires an Env created with SetUpTestWithSnapshotServer.
+func (e *Env) UpdateSnapshot(version string, resources ...cachev3.Resource) error {
+	snapshotServer, ok := e.managementServer.(*xdsService.SnapshotManagementServer)
+	if !ok {
+		return fmt.Errorf("Env was not set up with a snapshot-cache-backed management server")
+	}
+	return snapshotServer.UpdateSnapshot(version, resources...)
+}
+
+// WaitForAck blocks until the simulated proxy has acknowledged version for
+// typeURL, or 10 seconds elapse. It requires an Env created with
+// SetUpTestWithSnapshotServer.
+func (e *Env) WaitForAck(typeURL, version string) error {
+	snapshotServer, ok := e.managementServer.(*xdsService.SnapshotManagementServer)
+	if !ok {
+		return fmt.Errorf("Env was not set up with a snapshot-cache-backed management server")
+	}
+	return snapshotServer.WaitForAck(typeURL, version, 10*time.Second)
+}
+
+// CacheMetrics returns the STS server's response cache hit/miss/chunk
+// counters, for tests asserting on chunked-cache behavior.
+func (e *Env) CacheMetrics() stsServer.CacheMetrics {
+	return e.stsServer.Cache().Metrics()
+}
+
 func (e *Env) TearDown() {
 	// Stop proxy first, otherwise XDS stream is still alive and server's graceful
 	// stop will be blocked.
@@ -64,6 +107,9 @@ func (e *Env) TearDown() {
 	_ = e.AuthServer.Stop()
 	e.xDSServer.GracefulStop()
 	e.stsServer.Stop()
+	if e.ForwardProxy != nil {
+		_ = e.ForwardProxy.Stop()
+	}
 }
 
 func getDataFromFile(filePath string, t *testing.T) string {
@@ -145,15 +191,274 @@ func SetUpTest(t *testing.T, cb *xdsService.XDSCallbacks, testID uint16) *Env {
 
 	// Set up XDS server
 	env.ProxyListenerPort = int(proxySetUp.Ports().ClientProxyPort)
-	ls := &xdsService.DynamicListener{Port: env.ProxyListenerPort}
-	xds, err := xdsService.StartXDSServer(
+	staticServer := &xdsService.StaticManagementServer{}
+	xds, err := staticServer.Start(
+		xdsService.XDSConf{Port: int(proxySetUp.Ports().DiscoveryPort),
+			CertFile: istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-certificate.crt",
+			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, true)
+	if err != nil {
+		t.Fatalf("failed to start XDS server: %v", err)
+	}
+	env.xDSServer = xds
+	env.managementServer = staticServer
+
+	return env
+}
+
+// SetUpTestWithSnapshotServer is like SetUpTest, except the xDS management
+// server is backed by a go-control-plane snapshot cache rather than a single
+// static snapshot, so a single test run can push
+// LDS/CDS/RDS/SDS updates via Env.UpdateSnapshot and wait for them to be
+// acknowledged via Env.WaitForAck, without restarting Envoy.
+func SetUpTestWithSnapshotServer(t *testing.T, cb *xdsService.XDSCallbacks, testID uint16, nodeID string) *Env {
+	jwtToken := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/trustworthy-jwt.jwt", t)
+	if err := WriteDataToFile(proxyTokenPath, jwtToken); err != nil {
+		t.Fatalf("failed to set up token file %s: %v", proxyTokenPath, err)
+	}
+	caCert := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/ca-certificate.crt", t)
+	if err := WriteDataToFile(certPath, caCert); err != nil {
+		t.Fatalf("failed to set up ca certificate file %s: %v", certPath, err)
+	}
+
+	env := &Env{
+		initialToken: jwtToken,
+		NodeID:       nodeID,
+	}
+	proxySetUp := proxyEnv.NewTestSetup(testID, t)
+	proxySetUp.SetNoMixer(true)
+	proxySetUp.EnvoyTemplate = getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/bootstrap.yaml", t)
+	env.ProxySetUp = proxySetUp
+	env.DumpPortMap(t)
+
+	backend, err := tokenBackend.StartNewServer(t, tokenBackend.Config{
+		SubjectToken: jwtToken,
+		Port:         int(proxySetUp.Ports().MixerPort),
+		AccessToken:  cb.ExpectedToken(),
+	})
+	if err != nil {
+		t.Fatalf("failed to start a auth backend: %v", err)
+	}
+	env.AuthServer = backend
+
+	stsServer, err := setUpSTS(int(proxySetUp.Ports().ServerProxyPort), backend.URL)
+	if err != nil {
+		t.Fatalf("failed to start a STS server: %v", err)
+	}
+	env.stsServer = stsServer
+
+	env.WaitForStsFlowReady(t)
+
+	env.ProxyListenerPort = int(proxySetUp.Ports().ClientProxyPort)
+	snapshotServer := xdsService.NewSnapshotManagementServer(nodeID)
+	xds, err := snapshotServer.Start(
+		xdsService.XDSConf{Port: int(proxySetUp.Ports().DiscoveryPort),
+			CertFile: istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-certificate.crt",
+			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, true)
+	if err != nil {
+		t.Fatalf("failed to start snapshot-cache-backed XDS server: %v", err)
+	}
+	env.xDSServer = xds
+	env.managementServer = snapshotServer
+
+	return env
+}
+
+// SetUpTestWithAllowedAudienceDomains is like SetUpTest, except the STS
+// server rejects token exchange requests whose audience or resource domain
+// is not in allowedAudienceDomains. The readiness probe's own audience
+// ("audience") is always implicitly allowed, so callers only need to list
+// the domains their matrix test cases target.
+func SetUpTestWithAllowedAudienceDomains(t *testing.T, cb *xdsService.XDSCallbacks, testID uint16, allowedAudienceDomains []string) *Env {
+	jwtToken := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/trustworthy-jwt.jwt", t)
+	if err := WriteDataToFile(proxyTokenPath, jwtToken); err != nil {
+		t.Fatalf("failed to set up token file %s: %v", proxyTokenPath, err)
+	}
+	caCert := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/ca-certificate.crt", t)
+	if err := WriteDataToFile(certPath, caCert); err != nil {
+		t.Fatalf("failed to set up ca certificate file %s: %v", certPath, err)
+	}
+
+	env := &Env{
+		initialToken: jwtToken,
+	}
+	proxySetUp := proxyEnv.NewTestSetup(testID, t)
+	proxySetUp.SetNoMixer(true)
+	proxySetUp.EnvoyTemplate = getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/bootstrap.yaml", t)
+	env.ProxySetUp = proxySetUp
+	env.DumpPortMap(t)
+
+	backend, err := tokenBackend.StartNewServer(t, tokenBackend.Config{
+		SubjectToken: jwtToken,
+		Port:         int(proxySetUp.Ports().MixerPort),
+		AccessToken:  cb.ExpectedToken(),
+	})
+	if err != nil {
+		t.Fatalf("failed to start a auth backend: %v", err)
+	}
+	env.AuthServer = backend
+
+	// "audience" and "backend.example.com" are the audience/resource pair
+	// genStsReq uses for WaitForStsFlowReady's own readiness probe; they
+	// must always be allowed or the probe itself gets rejected by the
+	// whitelist this env exists to test.
+	domains := append([]string{"audience", "backend.example.com"}, allowedAudienceDomains...)
+	stsServer, err := setUpSTSWithAllowedAudienceDomains(int(proxySetUp.Ports().ServerProxyPort), backend.URL, domains)
+	if err != nil {
+		t.Fatalf("failed to start a STS server: %v", err)
+	}
+	env.stsServer = stsServer
+
+	env.WaitForStsFlowReady(t)
+
+	env.ProxyListenerPort = int(proxySetUp.Ports().ClientProxyPort)
+	staticServer := &xdsService.StaticManagementServer{}
+	xds, err := staticServer.Start(
+		xdsService.XDSConf{Port: int(proxySetUp.Ports().DiscoveryPort),
+			CertFile: istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-certificate.crt",
+			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, true)
+	if err != nil {
+		t.Fatalf("failed to start XDS server: %v", err)
+	}
+	env.xDSServer = xds
+	env.managementServer = staticServer
+
+	return env
+}
+
+// SetUpTestWithProxy is like SetUpTest, except the STS server's outbound
+// calls to the token backend are routed through an in-test forward proxy
+// (basicAuthUser/basicAuthPass, if non-empty, are required on the CONNECT
+// tunnel), exercising HTTPS_PROXY-style egress.
+func SetUpTestWithProxy(t *testing.T, cb *xdsService.XDSCallbacks, testID uint16, basicAuthUser, basicAuthPass string) *Env {
+	jwtToken := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/trustworthy-jwt.jwt", t)
+	if err := WriteDataToFile(proxyTokenPath, jwtToken); err != nil {
+		t.Fatalf("failed to set up token file %s: %v", proxyTokenPath, err)
+	}
+	caCert := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/ca-certificate.crt", t)
+	if err := WriteDataToFile(certPath, caCert); err != nil {
+		t.Fatalf("failed to set up ca certificate file %s: %v", certPath, err)
+	}
+
+	env := &Env{
+		initialToken: jwtToken,
+	}
+	proxySetUp := proxyEnv.NewTestSetup(testID, t)
+	proxySetUp.SetNoMixer(true)
+	proxySetUp.EnvoyTemplate = getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/bootstrap.yaml", t)
+	env.ProxySetUp = proxySetUp
+	env.DumpPortMap(t)
+
+	// TLS is required here so the STS server's outbound call is an https://
+	// destination: Go's http.Client only issues a CONNECT to proxyURL for an
+	// https:// destination, so a plain http:// backend would never actually
+	// drive the forward proxy's CONNECT tunnel.
+	backend, err := tokenBackend.StartNewServer(t, tokenBackend.Config{
+		SubjectToken: jwtToken,
+		Port:         int(proxySetUp.Ports().MixerPort),
+		AccessToken:  cb.ExpectedToken(),
+		TLS:          true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start a auth backend: %v", err)
+	}
+	env.AuthServer = backend
+
+	// Forward proxy sits on the test backend port; the auth backend and STS
+	// server ports are both already claimed above.
+	forwardProxy := StartForwardProxy(t, int(proxySetUp.Ports().BackendPort), basicAuthUser, basicAuthPass)
+	env.ForwardProxy = forwardProxy
+
+	proxyURL := "http://" + forwardProxy.Addr
+	if basicAuthUser != "" {
+		proxyURL = fmt.Sprintf("http://%s:%s@%s", basicAuthUser, basicAuthPass, forwardProxy.Addr)
+	}
+	proxyConfig := google.ProxyConfig{
+		ProxyURL: proxyURL,
+		// backend's certificate is self-signed and only asserted over the
+		// tunnel the proxy establishes, so skip verification rather than
+		// plumb a CA bundle through for a throwaway test certificate.
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	stsServer, err := setUpSTSWithProxy(int(proxySetUp.Ports().ServerProxyPort), backend.URL, proxyConfig)
+	if err != nil {
+		t.Fatalf("failed to start a STS server: %v", err)
+	}
+	env.stsServer = stsServer
+
+	env.WaitForStsFlowReady(t)
+
+	env.ProxyListenerPort = int(proxySetUp.Ports().ClientProxyPort)
+	staticServer := &xdsService.StaticManagementServer{}
+	xds, err := staticServer.Start(
+		xdsService.XDSConf{Port: int(proxySetUp.Ports().DiscoveryPort),
+			CertFile: istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-certificate.crt",
+			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, true)
+	if err != nil {
+		t.Fatalf("failed to start XDS server: %v", err)
+	}
+	env.xDSServer = xds
+	env.managementServer = staticServer
+
+	return env
+}
+
+// SetUpTestWithSmallCacheChunks is like SetUpTest, except the STS server's
+// response cache is forced to chunk at chunkThresholdBytes across
+// numShards, so the chunking code path is exercised deterministically
+// rather than only when a real federated token happens to be large.
+func SetUpTestWithSmallCacheChunks(t *testing.T, cb *xdsService.XDSCallbacks, testID uint16, chunkThresholdBytes, numShards int) *Env {
+	jwtToken := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/trustworthy-jwt.jwt", t)
+	if err := WriteDataToFile(proxyTokenPath, jwtToken); err != nil {
+		t.Fatalf("failed to set up token file %s: %v", proxyTokenPath, err)
+	}
+	caCert := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/ca-certificate.crt", t)
+	if err := WriteDataToFile(certPath, caCert); err != nil {
+		t.Fatalf("failed to set up ca certificate file %s: %v", certPath, err)
+	}
+
+	env := &Env{
+		initialToken: jwtToken,
+	}
+	proxySetUp := proxyEnv.NewTestSetup(testID, t)
+	proxySetUp.SetNoMixer(true)
+	proxySetUp.EnvoyTemplate = getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/bootstrap.yaml", t)
+	env.ProxySetUp = proxySetUp
+	env.DumpPortMap(t)
+
+	backend, err := tokenBackend.StartNewServer(t, tokenBackend.Config{
+		SubjectToken: jwtToken,
+		Port:         int(proxySetUp.Ports().MixerPort),
+		AccessToken:  cb.ExpectedToken(),
+	})
+	if err != nil {
+		t.Fatalf("failed to start a auth backend: %v", err)
+	}
+	env.AuthServer = backend
+
+	cacheOpts := stsServer.CacheOptions{
+		TTL:                 stsServer.DefaultCacheOptions().TTL,
+		NumShards:           numShards,
+		ChunkThresholdBytes: chunkThresholdBytes,
+	}
+	stsServer, err := setUpSTSWithCache(int(proxySetUp.Ports().ServerProxyPort), backend.URL, cacheOpts)
+	if err != nil {
+		t.Fatalf("failed to start a STS server: %v", err)
+	}
+	env.stsServer = stsServer
+
+	env.WaitForStsFlowReady(t)
+
+	env.ProxyListenerPort = int(proxySetUp.Ports().ClientProxyPort)
+	staticServer := &xdsService.StaticManagementServer{}
+	xds, err := staticServer.Start(
 		xdsService.XDSConf{Port: int(proxySetUp.Ports().DiscoveryPort),
 			CertFile: istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-certificate.crt",
-			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, cb, ls, true)
+			KeyFile:  istioEnv.IstioSrc + "/security/pkg/stsservice/test/testdata/server-key.key"}, true)
 	if err != nil {
 		t.Fatalf("failed to start XDS server: %v", err)
 	}
 	env.xDSServer = xds
+	env.managementServer = staticServer
 
 	return env
 }
@@ -218,10 +523,17 @@ func (e *Env) WaitForStsFlowReady(t *testing.T) {
 }
 
 func (e *Env) genStsReq(stsAddr string) (req *http.Request) {
+	return e.genStsReqForAudience(stsAddr, "audience", "https://backend.example.com")
+}
+
+// genStsReqForAudience is like genStsReq but lets callers set the audience
+// and resource parameters, so tests can exercise the server's domain
+// whitelist across allowed, disallowed, and subdomain-boundary cases.
+func (e *Env) genStsReqForAudience(stsAddr, audience, resource string) (req *http.Request) {
 	stsQuery := url.Values{}
 	stsQuery.Set("grant_type", stsServer.TokenExchangeGrantType)
-	stsQuery.Set("resource", "https//:backend.example.com")
-	stsQuery.Set("audience", "audience")
+	stsQuery.Set("resource", resource)
+	stsQuery.Set("audience", audience)
 	stsQuery.Set("scope", "https://www.googleapis.com/auth/cloud-platform")
 	stsQuery.Set("requested_token_type", "urn:ietf:params:oauth:token-type:access_token")
 	stsQuery.Set("subject_token", e.initialToken)
@@ -234,9 +546,52 @@ func (e *Env) genStsReq(stsAddr string) (req *http.Request) {
 	return req
 }
 
+// SendStsReqForAudience issues a token exchange request for audience against
+// the Env's STS server and returns the raw HTTP response, for tests driving
+// the domain-whitelist matrix end-to-end.
+func (e *Env) SendStsReqForAudience(t *testing.T, audience, resource string) *http.Response {
+	addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", e.ProxySetUp.Ports().ServerProxyPort))
+	req := e.genStsReqForAudience(addr.String(), audience, resource)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send STS request: %v", err)
+	}
+	return resp
+}
+
 func setUpSTS(stsPort int, backendURL string) (*stsServer.Server, error) {
+	return setUpSTSWithOpts(stsPort, backendURL, google.ProxyConfig{}, stsServer.CacheOptions{}, nil)
+}
+
+// setUpSTSWithProxy is like setUpSTS but routes the google plugin's outbound
+// calls to the token backend through proxyCfg, so tests can verify STS
+// traffic actually crosses an egress proxy.
+func setUpSTSWithProxy(stsPort int, backendURL string, proxyCfg google.ProxyConfig) (*stsServer.Server, error) {
+	return setUpSTSWithOpts(stsPort, backendURL, proxyCfg, stsServer.CacheOptions{}, nil)
+}
+
+// setUpSTSWithCache is like setUpSTS but overrides the server's response
+// cache options, so tests can force a small chunk size to exercise the
+// chunked-cache code path deterministically.
+func setUpSTSWithCache(stsPort int, backendURL string, cacheOpts stsServer.CacheOptions) (*stsServer.Server, error) {
+	return setUpSTSWithOpts(stsPort, backendURL, google.ProxyConfig{}, cacheOpts, nil)
+}
+
+// setUpSTSWithAllowedAudienceDomains is like setUpSTS but restricts token
+// exchange to the given audience/resource domain whitelist.
+func setUpSTSWithAllowedAudienceDomains(stsPort int, backendURL string, allowedAudienceDomains []string) (*stsServer.Server, error) {
+	return setUpSTSWithOpts(stsPort, backendURL, google.ProxyConfig{}, stsServer.CacheOptions{}, allowedAudienceDomains)
+}
+
+func setUpSTSWithOpts(stsPort int, backendURL string, proxyCfg google.ProxyConfig, cacheOpts stsServer.CacheOptions,
+	allowedAudienceDomains []string) (*stsServer.Server, error) {
+	transport, err := google.NewProxyAwareTransport(proxyCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build proxy-aware transport: %v", err)
+	}
 	// Create token exchange Google plugin
-	tokenExchangePlugin, _ := google.CreateTokenManagerPlugin(tokenBackend.FakeTrustDomain, tokenBackend.FakeProjectNum)
+	tokenExchangePlugin, _ := google.CreateTokenManagerPluginWithConfig(
+		tokenBackend.FakeTrustDomain, tokenBackend.FakeProjectNum, google.TokenManagerConfig{Transport: transport})
 	federatedTokenTestingEndpoint := backendURL + "/v1/identitybindingtoken"
 	accessTokenTestingEndpoint := backendURL + "/v1/projects/-/serviceAccounts/service-%s@gcp-sa-meshdataplane.iam.gserviceaccount.com:generateAccessToken"
 	tokenExchangePlugin.SetEndpoints(federatedTokenTestingEndpoint, accessTokenTestingEndpoint)
@@ -249,5 +604,104 @@ func setUpSTS(stsPort int, backendURL string) (*stsServer.Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create address %v", err)
 	}
-	return stsServer.NewServer(stsServer.Config{LocalHostAddr: addr.IP.String(), LocalPort: addr.Port}, tm)
+	return stsServer.NewServer(
+		stsServer.Config{
+			LocalHostAddr:          addr.IP.String(),
+			LocalPort:              addr.Port,
+			CacheOptions:           cacheOpts,
+			AllowedAudienceDomains: allowedAudienceDomains,
+		}, tm)
+}
+
+// OIDCEnv is a lighter-weight sibling of Env that only stands up an STS
+// server backed by the oidc plugin and a fake OIDC issuer, so refresh-token
+// behavior can be exercised without the full Envoy/XDS flow.
+type OIDCEnv struct {
+	IssuerServer *oidcBackend.Issuer
+
+	stsServer    *stsServer.Server
+	stsPort      int
+	initialToken string
+}
+
+// TearDown stops the STS server and the fake OIDC issuer.
+func (e *OIDCEnv) TearDown() {
+	_ = e.IssuerServer.Stop()
+	e.stsServer.Stop()
+}
+
+// SetUpOIDCTest starts a fake OIDC issuer and an STS server configured with
+// the oidc token manager plugin pointed at it, so that the refresh_token
+// grant path can be driven end-to-end.
+func SetUpOIDCTest(t *testing.T, testID uint16) *OIDCEnv {
+	issuerPort := int(proxyEnv.NewTestSetup(testID, t).Ports().MixerPort)
+	jwtToken := getDataFromFile(istioEnv.IstioSrc+"/security/pkg/stsservice/test/testdata/trustworthy-jwt.jwt", t)
+
+	issuer, err := oidcBackend.StartNewServer(t, oidcBackend.Config{
+		Port:         issuerPort,
+		AccessToken:  "initial-access-token",
+		RefreshToken: "initial-refresh-token",
+		ExpiresIn:    1, // expire almost immediately so the refresh path is exercised in a short test run
+	})
+	if err != nil {
+		t.Fatalf("failed to start fake OIDC issuer: %v", err)
+	}
+
+	oidcPlugin, err := oidc.CreateTokenManagerPlugin(issuer.URL, "test-client", "test-secret")
+	if err != nil {
+		t.Fatalf("failed to create OIDC plugin: %v", err)
+	}
+	oidcPlugin.RefreshSkew = 2 * time.Second
+
+	tm := tokenmanager.CreateTokenManager(tokenmanager.OIDCTokenExchange,
+		tokenmanager.Config{TrustDomain: "oidc-test-trust-domain"})
+	tm.(*tokenmanager.TokenManager).SetPlugin(oidcPlugin)
+
+	stsPort := int(proxyEnv.NewTestSetup(testID+1, t).Ports().ServerProxyPort)
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", stsPort))
+	if err != nil {
+		t.Fatalf("failed to create STS server address: %v", err)
+	}
+	sts, err := stsServer.NewServer(stsServer.Config{
+		LocalHostAddr: addr.IP.String(),
+		LocalPort:     addr.Port,
+		// The response cache would otherwise serve the second SendStsReq
+		// call from the first call's entry (identical params, same cache
+		// key), so the oidc plugin would never be re-invoked and the
+		// refresh_token grant this env exists to exercise would never
+		// happen. A near-zero TTL keeps every call live.
+		CacheOptions: stsServer.CacheOptions{TTL: time.Nanosecond},
+	}, tm)
+	if err != nil {
+		t.Fatalf("failed to start STS server: %v", err)
+	}
+
+	return &OIDCEnv{
+		IssuerServer: issuer,
+		stsServer:    sts,
+		stsPort:      stsPort,
+		initialToken: jwtToken,
+	}
+}
+
+// SendStsReq issues a token exchange request for e's fixed subject token
+// against the Env's STS server and returns the raw HTTP response, so tests
+// can drive the oidc plugin's initial-exchange and refresh-token code paths
+// with repeated calls.
+func (e *OIDCEnv) SendStsReq(t *testing.T) *http.Response {
+	stsQuery := url.Values{}
+	stsQuery.Set("grant_type", stsServer.TokenExchangeGrantType)
+	stsQuery.Set("audience", "audience")
+	stsQuery.Set("scope", "https://www.googleapis.com/auth/cloud-platform")
+	stsQuery.Set("requested_token_type", "urn:ietf:params:oauth:token-type:access_token")
+	stsQuery.Set("subject_token", e.initialToken)
+	stsQuery.Set("subject_token_type", stsServer.SubjectTokenType)
+	stsURL := fmt.Sprintf("http://127.0.0.1:%d%s", e.stsPort, stsServer.TokenPath)
+	req, _ := http.NewRequest("POST", stsURL, strings.NewReader(stsQuery.Encode()))
+	req.Header.Set("Content-Type", stsServer.URLEncodedForm)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send STS request: %v", err)
+	}
+	return resp
 }
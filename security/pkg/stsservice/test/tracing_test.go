@@ -0,0 +1,41 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	xdsService "istio.io/istio/security/pkg/stsservice/mock"
+)
+
+// TestTraceHeaderPropagatedToBackend verifies that a token exchange request
+// causes the STS server to propagate a trace header onto its outbound call
+// to the auth backend, so an incoming request's trace can be followed
+// through the whole exchange.
+func TestTraceHeaderPropagatedToBackend(t *testing.T) {
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTest(t, cb, 1640)
+	defer env.TearDown()
+
+	resp := env.SendStsReqForAudience(t, "audience", "https://backend.example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a successful token exchange, got status %d", resp.StatusCode)
+	}
+
+	if env.AuthServer.LastTraceHeader() == "" {
+		t.Errorf("expected the STS server to propagate a B3 trace header to the auth backend")
+	}
+}
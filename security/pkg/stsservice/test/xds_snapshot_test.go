@@ -0,0 +1,101 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+
+	xdsService "istio.io/istio/security/pkg/stsservice/mock"
+)
+
+const listenerTypeURL = "type.googleapis.com/envoy.config.listener.v3.Listener"
+
+// TestSnapshotUpdateAck drives the snapshot-cache-backed management server
+// with a bare ADS client (rather than a full Envoy), pushes a new snapshot
+// version mid-test via Env.UpdateSnapshot, and confirms Env.WaitForAck
+// observes the new version once the server pushes it to the open stream.
+func TestSnapshotUpdateAck(t *testing.T) {
+	nodeID := "test-node"
+	cb := xdsService.NewXDSCallbacks("expected-access-token")
+	env := SetUpTestWithSnapshotServer(t, cb, 1630, nodeID)
+	defer env.TearDown()
+
+	conn, err := grpc.Dial(
+		fmt.Sprintf("127.0.0.1:%d", env.ProxySetUp.Ports().DiscoveryPort),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial xDS server: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := discovery.NewAggregatedDiscoveryServiceClient(conn)
+	stream, err := client.StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatalf("failed to open ADS stream: %v", err)
+	}
+
+	if err := stream.Send(&discovery.DiscoveryRequest{
+		Node:    &core.Node{Id: nodeID},
+		TypeUrl: listenerTypeURL,
+	}); err != nil {
+		t.Fatalf("failed to send initial discovery request: %v", err)
+	}
+	// Stand in for Envoy: ACK every response by echoing its version back in
+	// a follow-up DiscoveryRequest, which is what Env.WaitForAck actually
+	// waits to observe.
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if err := stream.Send(&discovery.DiscoveryRequest{
+				Node:          &core.Node{Id: nodeID},
+				TypeUrl:       resp.TypeUrl,
+				VersionInfo:   resp.VersionInfo,
+				ResponseNonce: resp.Nonce,
+			}); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := env.UpdateSnapshot("v1", &listenerv3.Listener{Name: "test-listener"}); err != nil {
+		t.Fatalf("failed to push snapshot v1: %v", err)
+	}
+	if err := env.WaitForAck(listenerTypeURL, "v1"); err != nil {
+		t.Errorf("waiting for v1 to be observed: %v", err)
+	}
+
+	if err := env.UpdateSnapshot("v2", &listenerv3.Listener{Name: "test-listener"}); err != nil {
+		t.Fatalf("failed to push snapshot v2: %v", err)
+	}
+	if err := env.WaitForAck(listenerTypeURL, "v2"); err != nil {
+		t.Errorf("waiting for v2 to be observed: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mock provides a fake backend for the GCP identitybindingtoken and
+// generateAccessToken endpoints the google token exchange plugin talks to.
+package mock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// FakeTrustDomain is the trust domain used throughout the STS test harness.
+	FakeTrustDomain = "testdomain.com"
+	// FakeProjectNum is the GCP project number used throughout the STS test harness.
+	FakeProjectNum = "1234567890"
+)
+
+// Config controls the behavior of the fake auth backend.
+type Config struct {
+	// SubjectToken is the only subject token the backend will accept.
+	SubjectToken string
+	// Port the backend should listen on.
+	Port int
+	// AccessToken is returned by the generateAccessToken endpoint.
+	AccessToken string
+	// TLS serves the backend over HTTPS with an ephemeral, self-signed
+	// certificate, so tests that route through an HTTP forward proxy
+	// actually exercise the CONNECT tunnel: Go's http.Client only issues
+	// CONNECT for an https:// destination, never for a plain http:// one.
+	TLS bool
+}
+
+// AuthorizationServer is a fake backend standing in for the federated-token
+// and access-token GCP endpoints.
+type AuthorizationServer struct {
+	URL string
+
+	cfg      Config
+	server   *http.Server
+	listener net.Listener
+
+	mu          sync.Mutex
+	traceHeader string // last B3 trace header seen, for tracing-propagation assertions
+}
+
+// StartNewServer starts a fake auth backend on cfg.Port.
+func StartNewServer(t *testing.T, cfg Config) (*AuthorizationServer, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if cfg.TLS {
+		cert, err := selfSignedCert()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate fake auth backend TLS certificate: %v", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+		scheme = "https"
+	}
+	a := &AuthorizationServer{
+		URL:      fmt.Sprintf("%s://%s", scheme, listener.Addr().String()),
+		cfg:      cfg,
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/identitybindingtoken", a.federatedTokenHandler)
+	mux.HandleFunc("/v1/projects/-/serviceAccounts/", a.accessTokenHandler)
+	a.server = &http.Server{Handler: mux}
+	go func() {
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Logf("fake auth backend stopped serving: %v", err)
+		}
+	}()
+	return a, nil
+}
+
+// selfSignedCert generates an ephemeral, self-signed RSA certificate for
+// 127.0.0.1, valid for an hour: long enough for a test run, short enough to
+// not matter if it's ever left lying around.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Stop shuts down the fake auth backend.
+func (a *AuthorizationServer) Stop() error {
+	return a.server.Close()
+}
+
+// LastTraceHeader returns the B3 "X-B3-Traceid" header value seen on the
+// most recent request, so tests can assert that the STS server propagated
+// its trace context to this backend.
+func (a *AuthorizationServer) LastTraceHeader() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.traceHeader
+}
+
+func (a *AuthorizationServer) recordTraceHeader(r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.traceHeader = r.Header.Get("X-B3-Traceid")
+}
+
+func (a *AuthorizationServer) federatedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	a.recordTraceHeader(r)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "fake-federated-token"})
+}
+
+func (a *AuthorizationServer) accessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	a.recordTraceHeader(r)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"accessToken": a.cfg.AccessToken,
+		"expireTime":  "2099-01-01T00:00:00Z",
+	})
+}
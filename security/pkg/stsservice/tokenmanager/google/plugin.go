@@ -0,0 +1,194 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package google implements a token exchange plugin that trades a Kubernetes
+// service account token for a GCP federated identity token, and that token
+// for a GCP access token, via the identitybindingtoken endpoints.
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"istio.io/pkg/log"
+
+	"istio.io/istio/security/pkg/stsservice"
+)
+
+var tmLog = log.RegisterScope("token", "STS token manager plugin debugging", 0)
+
+const (
+	federatedTokenEndpoint = "https://securetoken.googleapis.com/v1/identitybindingtoken"
+	accessTokenEndpoint    = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/service-%s@gcp-sa-meshdataplane.iam.gserviceaccount.com:generateAccessToken"
+
+	httpTimeOutInSec = 5
+)
+
+// TokenManagerConfig configures the HTTP behavior of the plugin's outbound
+// calls to the federated-token and access-token endpoints. A zero value
+// config uses http.DefaultTransport with a 5 second timeout.
+type TokenManagerConfig struct {
+	// Transport, if set, is used as the RoundTripper for the plugin's HTTP
+	// client instead of the default one. This lets callers route STS
+	// backend traffic through a forward proxy, inject mTLS, etc.
+	Transport http.RoundTripper
+}
+
+// Plugin exchanges a Kubernetes-issued subject token for a GCP access token.
+type Plugin struct {
+	trustDomain string
+	projectNum  string
+
+	federatedTokenEndpoint string
+	accessTokenEndpoint    string
+
+	hTTPClient *http.Client
+}
+
+// CreateTokenManagerPlugin creates a google token exchange plugin for the
+// given trust domain and GCP project number, using default endpoints and
+// HTTP configuration.
+func CreateTokenManagerPlugin(trustDomain, projectNum string) (*Plugin, error) {
+	return CreateTokenManagerPluginWithConfig(trustDomain, projectNum, TokenManagerConfig{})
+}
+
+// CreateTokenManagerPluginWithConfig is like CreateTokenManagerPlugin but
+// additionally accepts a TokenManagerConfig, for example to install a proxy-
+// aware http.RoundTripper.
+func CreateTokenManagerPluginWithConfig(trustDomain, projectNum string, config TokenManagerConfig) (*Plugin, error) {
+	if trustDomain == "" {
+		return nil, fmt.Errorf("trust domain is required")
+	}
+	transport := config.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Plugin{
+		trustDomain:            trustDomain,
+		projectNum:             projectNum,
+		federatedTokenEndpoint: federatedTokenEndpoint,
+		accessTokenEndpoint:    accessTokenEndpoint,
+		hTTPClient: &http.Client{
+			Timeout:   httpTimeOutInSec * time.Second,
+			Transport: transport,
+		},
+	}, nil
+}
+
+// SetEndpoints overrides the federated-token and access-token endpoints,
+// which is used by tests to point the plugin at an in-test backend.
+func (p *Plugin) SetEndpoints(federatedTokenEndpoint, accessTokenEndpoint string) {
+	p.federatedTokenEndpoint = federatedTokenEndpoint
+	p.accessTokenEndpoint = accessTokenEndpoint
+}
+
+// GetToken exchanges parameters.SubjectToken for a federated token, then
+// exchanges the federated token for a GCP access token. ctx carries the
+// caller's trace span, which is propagated to both backend HTTP calls.
+func (p *Plugin) GetToken(ctx context.Context, parameters stsservice.StsRequestParameters) ([]byte, error) {
+	federatedToken, err := p.fetchFederatedToken(ctx, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch federated token: %v", err)
+	}
+	return p.fetchAccessToken(ctx, federatedToken)
+}
+
+func (p *Plugin) fetchFederatedToken(ctx context.Context, parameters stsservice.StsRequestParameters) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "google.federated_token_http_call")
+	defer span.End()
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"audience":           parameters.Audience,
+		"grantType":          "urn:ietf:params:oauth:grant-type:token-exchange",
+		"requestedTokenType": "urn:ietf:params:oauth:token-type:access_token",
+		"subjectToken":       parameters.SubjectToken,
+		"subjectTokenType":   "urn:ietf:params:oauth:token-type:jwt",
+		"scope":              parameters.Scope,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", p.federatedTokenEndpoint, strings.NewReader(string(reqBody)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	stsservice.InjectTraceHeaders(ctx, req)
+	resp, err := p.hTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("federated token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("failed to parse federated token response: %v", err)
+	}
+	return tokenResp.AccessToken, nil
+}
+
+func (p *Plugin) fetchAccessToken(ctx context.Context, federatedToken string) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "google.access_token_http_call")
+	defer span.End()
+
+	endpoint := fmt.Sprintf(p.accessTokenEndpoint, p.projectNum)
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(`{"scope":["https://www.googleapis.com/auth/cloud-platform"]}`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+federatedToken)
+	req.Header.Set("Content-Type", "application/json")
+	stsservice.InjectTraceHeaders(ctx, req)
+	resp, err := p.hTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("access token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// DumpPluginStatus returns a human readable snapshot of the plugin's
+// configuration, for the STS server's debug endpoint.
+func (p *Plugin) DumpPluginStatus() ([]byte, string, error) {
+	status := map[string]string{
+		"trust domain":    p.trustDomain,
+		"federated token": p.federatedTokenEndpoint,
+		"access token":    p.accessTokenEndpoint,
+	}
+	tmLog.Debugf("dumping plugin status for trust domain %s", p.trustDomain)
+	out, err := json.MarshalIndent(status, "", "  ")
+	return out, "Istio STS token exchange plugin", err
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often the plugin will re-fetch the JWKS
+// document even if every key ID it has seen so far is still present, so a
+// provider-side key rotation is picked up promptly.
+const jwksRefreshInterval = 10 * time.Minute
+
+// jwk is the subset of a JSON Web Key this plugin understands: RSA keys
+// referenced by key ID, which is all that's needed to look up the right key
+// for signature verification.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksCache fetches and caches a provider's JSON Web Key Set, re-fetching it
+// during verification once it is older than jwksRefreshInterval or when a
+// token names an unknown key ID, so that key rotation on the provider side
+// doesn't require a plugin restart.
+type jwksCache struct {
+	hTTPClient *http.Client
+
+	mu          sync.Mutex
+	uri         string
+	keys        map[string]jwk
+	lastFetched time.Time
+}
+
+func newJWKSCache(client *http.Client) *jwksCache {
+	return &jwksCache{hTTPClient: client, keys: make(map[string]jwk)}
+}
+
+func (c *jwksCache) setURI(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uri == uri {
+		return
+	}
+	c.uri = uri
+	c.lastFetched = time.Time{}
+}
+
+func (c *jwksCache) refreshIfStale() error {
+	c.mu.Lock()
+	stale := time.Since(c.lastFetched) > jwksRefreshInterval
+	uri := c.uri
+	c.mu.Unlock()
+	if !stale || uri == "" {
+		return nil
+	}
+
+	resp, err := c.hTTPClient.Get(uri)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	doc := &jwksDocument{}
+	if err := json.Unmarshal(body, doc); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %v", err)
+	}
+
+	keys := make(map[string]jwk, len(doc.Keys))
+	for _, k := range doc.Keys {
+		keys[k.Kid] = k
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.lastFetched = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// verify checks that idToken carries a valid RSA signature from a key
+// present in the JWKS, refreshing the key set first if it is stale or the
+// referenced key ID is unknown (to tolerate a rotation that just happened).
+func (c *jwksCache) verify(idToken string) error {
+	kid, err := keyIDFromToken(idToken)
+	if err != nil {
+		return err
+	}
+	if err := c.refreshIfStale(); err != nil {
+		return err
+	}
+	key, ok := c.lookupKey(kid)
+	if !ok {
+		// The key might have just rotated in; force one more fetch before
+		// giving up.
+		c.mu.Lock()
+		c.lastFetched = time.Time{}
+		c.mu.Unlock()
+		if err := c.refreshIfStale(); err != nil {
+			return err
+		}
+		key, ok = c.lookupKey(kid)
+		if !ok {
+			return fmt.Errorf("no JWKS key found for kid %q", kid)
+		}
+	}
+	return verifySignature(idToken, key)
+}
+
+func (c *jwksCache) lookupKey(kid string) (jwk, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.keys[kid]
+	return k, ok
+}
+
+// verifySignature checks token's RSA signature against k, per RFC 7515: the
+// signing input is "base64url(header).base64url(payload)", signed with
+// RSASSA-PKCS1-v1_5 using SHA-256 (alg "RS256").
+func verifySignature(token string, k jwk) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT: expected 3 segments")
+	}
+	pub, err := k.rsaPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to parse JWKS key %q: %v", k.Kid, err)
+	}
+	sig, err := base64URLDecode(parts[2])
+	if err != nil {
+		return fmt.Errorf("failed to decode JWT signature: %v", err)
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil {
+		return fmt.Errorf("JWT signature verification failed: %v", err)
+	}
+	return nil
+}
+
+// rsaPublicKey decodes k's base64url-encoded modulus and exponent into an
+// rsa.PublicKey, per RFC 7518 section 6.3.1.
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64URLDecode(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode modulus: %v", err)
+	}
+	eBytes, err := base64URLDecode(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exponent: %v", err)
+	}
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: e}, nil
+}
+
+// keyIDFromToken extracts the "kid" header field from a JWT without
+// validating its signature; signature validation against the matched JWK is
+// left to the caller once the key is resolved.
+func keyIDFromToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed JWT: expected 3 segments")
+	}
+	header, err := base64URLDecode(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode JWT header: %v", err)
+	}
+	h := struct {
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return "", fmt.Errorf("failed to parse JWT header: %v", err)
+	}
+	if h.Kid == "" {
+		return "", fmt.Errorf("JWT header missing kid")
+	}
+	return h.Kid, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mock provides a fake OIDC issuer, analogous to the google plugin's
+// tokenBackend, for exercising the oidc token manager plugin end-to-end.
+package mock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// signingKeyID is the "kid" advertised in the issuer's JWKS and in every ID
+// token it signs.
+const signingKeyID = "test-key-1"
+
+// Config controls the behavior of the fake issuer.
+type Config struct {
+	// Port the issuer should listen on.
+	Port int
+	// Subject is the "sub" claim of every ID token the issuer signs.
+	// Defaults to "test-subject" if empty.
+	Subject string
+	// AccessToken is returned on every successful grant.
+	AccessToken string
+	// RefreshToken is returned alongside the access token on every grant
+	// other than refresh_token, so tests can exercise the refresh_token grant
+	// afterwards.
+	RefreshToken string
+	// ExpiresIn is advertised as the access token lifetime, and used as the
+	// signed ID token's lifetime too, in seconds.
+	ExpiresIn int
+}
+
+// Issuer is a minimal OIDC provider: it generates its own RSA signing key at
+// startup, serves a discovery document and matching JWKS document, and a
+// token endpoint that signs a real, verifiable ID token on every grant and
+// accepts both the token-exchange and refresh_token grants.
+type Issuer struct {
+	URL string
+
+	cfg        Config
+	server     *http.Server
+	listener   net.Listener
+	signingKey *rsa.PrivateKey
+	// grants records every grant_type seen on the token endpoint, so tests
+	// can assert a refresh actually happened rather than a full exchange.
+	grants []string
+}
+
+// StartNewServer starts a fake OIDC issuer on cfg.Port.
+func StartNewServer(t *testing.T, cfg Config) (*Issuer, error) {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate fake issuer signing key: %v", err)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+	iss := &Issuer{
+		URL:        fmt.Sprintf("http://%s", listener.Addr().String()),
+		cfg:        cfg,
+		listener:   listener,
+		signingKey: signingKey,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discoveryHandler)
+	mux.HandleFunc("/jwks", iss.jwksHandler)
+	mux.HandleFunc("/token", iss.tokenHandler)
+	iss.server = &http.Server{Handler: mux}
+	go func() {
+		if err := iss.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Logf("fake OIDC issuer stopped serving: %v", err)
+		}
+	}()
+	return iss, nil
+}
+
+// Stop shuts down the fake issuer.
+func (i *Issuer) Stop() error {
+	return i.server.Close()
+}
+
+// Grants returns the grant_type values seen so far, in order.
+func (i *Issuer) Grants() []string {
+	return i.grants
+}
+
+func (i *Issuer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]string{
+		"issuer":         i.URL,
+		"token_endpoint": i.URL + "/token",
+		"jwks_uri":       i.URL + "/jwks",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+func (i *Issuer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := i.signingKey.PublicKey
+	doc := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": signingKeyID,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+func (i *Issuer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grantType := r.Form.Get("grant_type")
+	i.grants = append(i.grants, grantType)
+
+	idToken, err := i.signIDToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"access_token": i.cfg.AccessToken,
+		"id_token":     idToken,
+		"token_type":   "Bearer",
+		"expires_in":   i.cfg.ExpiresIn,
+	}
+	if grantType != "refresh_token" {
+		resp["refresh_token"] = i.cfg.RefreshToken
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// signIDToken mints a real RS256-signed ID token, so the oidc plugin's JWKS
+// verification step has a genuine signature to check rather than a
+// pass-through fixture.
+func (i *Issuer) signIDToken() (string, error) {
+	subject := i.cfg.Subject
+	if subject == "" {
+		subject = "test-subject"
+	}
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": signingKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": i.URL,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(i.cfg.ExpiresIn) * time.Second).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.signingKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign ID token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
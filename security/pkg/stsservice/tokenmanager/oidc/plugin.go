@@ -0,0 +1,283 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidc implements a generic OpenID Connect token exchange plugin for
+// the STS token manager. Unlike the google plugin, which is tied to GCP's
+// identity binding token endpoints, this plugin discovers its endpoints from
+// the issuer's `.well-known/openid-configuration` document and is suitable
+// for any OIDC-compliant identity provider.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/pkg/log"
+
+	"istio.io/istio/security/pkg/stsservice"
+)
+
+var oidcLog = log.RegisterScope("oidc", "OIDC token exchange plugin debugging", 0)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// defaultRefreshSkew is how far ahead of an access token's expiry the
+	// plugin will proactively refresh it, so callers rarely observe an
+	// expired token in the cache.
+	defaultRefreshSkew = 2 * time.Minute
+
+	httpTimeOutInSec = 5
+)
+
+// discoveryDocument is the subset of the OIDC discovery document this
+// plugin depends on.
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+}
+
+// cachedToken holds the most recently minted credentials for a subject, plus
+// everything needed to refresh them without re-running the subject-token
+// exchange.
+type cachedToken struct {
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// Plugin fetches OIDC-issued access tokens on behalf of a subject token,
+// transparently refreshing them with the provider's refresh_token grant
+// before they expire. It implements the tokenmanager.Plugin interface.
+type Plugin struct {
+	issuerURL    string
+	clientID     string
+	clientSecret string
+
+	// RefreshSkew controls how long before expiry a cached token is
+	// refreshed instead of reused. Exported for tests.
+	RefreshSkew time.Duration
+
+	hTTPClient *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	jwks      *jwksCache
+	tokens    map[string]*cachedToken // keyed by subject token
+}
+
+// CreateTokenManagerPlugin creates a plugin instance that exchanges subject
+// tokens for access tokens issued by the OIDC provider at issuerURL.
+func CreateTokenManagerPlugin(issuerURL, clientID, clientSecret string) (*Plugin, error) {
+	if issuerURL == "" {
+		return nil, fmt.Errorf("issuer URL is required")
+	}
+	p := &Plugin{
+		issuerURL:    strings.TrimSuffix(issuerURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		RefreshSkew:  defaultRefreshSkew,
+		hTTPClient:   &http.Client{Timeout: httpTimeOutInSec * time.Second},
+		tokens:       make(map[string]*cachedToken),
+	}
+	p.jwks = newJWKSCache(p.hTTPClient)
+	if err := p.refreshDiscovery(); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %v", err)
+	}
+	return p, nil
+}
+
+func (p *Plugin) refreshDiscovery() error {
+	resp, err := p.hTTPClient.Get(p.issuerURL + discoveryPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	doc := &discoveryDocument{}
+	if err := json.Unmarshal(body, doc); err != nil {
+		return fmt.Errorf("failed to parse discovery document: %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.discovery = doc
+	p.jwks.setURI(doc.JwksURI)
+	return nil
+}
+
+// GetToken exchanges parameters.subjectToken for an access token, reusing
+// and, when necessary, refreshing a cached token rather than always running
+// the full grant. ctx carries the caller's trace span, which is propagated
+// to the token endpoint.
+func (p *Plugin) GetToken(ctx context.Context, parameters stsservice.StsRequestParameters) ([]byte, error) {
+	subject := parameters.SubjectToken
+	if subject == "" {
+		return nil, fmt.Errorf("subject token is empty")
+	}
+
+	if tok := p.lookupFreshToken(subject); tok != nil {
+		return p.buildResponse(tok)
+	}
+
+	p.mu.Lock()
+	cached, hasRefresh := p.tokens[subject]
+	p.mu.Unlock()
+	if hasRefresh && cached.refreshToken != "" {
+		tok, err := p.refreshAccessToken(ctx, cached.refreshToken)
+		if err == nil {
+			// A refresh response may omit refresh_token, in which case the
+			// current one stays valid and is kept for the next refresh.
+			if tok.refreshToken == "" {
+				tok.refreshToken = cached.refreshToken
+			}
+			p.storeToken(subject, tok)
+			return p.buildResponse(tok)
+		}
+		oidcLog.Warnf("refresh_token grant failed, falling back to full exchange: %v", err)
+	}
+
+	tok, err := p.exchangeSubjectToken(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	p.storeToken(subject, tok)
+	return p.buildResponse(tok)
+}
+
+// lookupFreshToken returns the cached token for subject if it is not within
+// RefreshSkew of expiring, or nil otherwise.
+func (p *Plugin) lookupFreshToken(subject string) *cachedToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tok, ok := p.tokens[subject]
+	if !ok {
+		return nil
+	}
+	if time.Until(tok.expiry) <= p.RefreshSkew {
+		return nil
+	}
+	return tok
+}
+
+// storeToken atomically swaps the cached credentials for subject.
+func (p *Plugin) storeToken(subject string, tok *cachedToken) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[subject] = tok
+}
+
+func (p *Plugin) tokenEndpoint() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discovery.TokenEndpoint
+}
+
+// exchangeSubjectToken performs the initial token exchange grant for subject
+// and verifies the returned ID token against the provider's JWKS.
+func (p *Plugin) exchangeSubjectToken(ctx context.Context, subject string) (*cachedToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:token-exchange")
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	form.Set("subject_token", subject)
+	form.Set("subject_token_type", "urn:ietf:params:oauth:token-type:jwt")
+	return p.doTokenRequest(ctx, form)
+}
+
+// refreshAccessToken swaps a still-valid refresh token for a new access
+// token via grant_type=refresh_token.
+func (p *Plugin) refreshAccessToken(ctx context.Context, refreshToken string) (*cachedToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	form.Set("refresh_token", refreshToken)
+	return p.doTokenRequest(ctx, form)
+}
+
+type tokenEndpointResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (p *Plugin) doTokenRequest(ctx context.Context, form url.Values) (*cachedToken, error) {
+	req, err := http.NewRequest("POST", p.tokenEndpoint(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	stsservice.InjectTraceHeaders(ctx, req)
+	resp, err := p.hTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	tr := &tokenEndpointResponse{}
+	if err := json.Unmarshal(body, tr); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %v", err)
+	}
+	if tr.IDToken != "" {
+		if err := p.jwks.verify(tr.IDToken); err != nil {
+			return nil, fmt.Errorf("ID token verification failed: %v", err)
+		}
+	}
+	return &cachedToken{
+		accessToken:  tr.AccessToken,
+		refreshToken: tr.RefreshToken,
+		expiry:       time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (p *Plugin) buildResponse(tok *cachedToken) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"access_token":      tok.accessToken,
+		"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+		"token_type":        "Bearer",
+		"expires_in":        int(time.Until(tok.expiry).Seconds()),
+	})
+}
+
+// DumpPluginStatus returns a human readable snapshot of cached subjects, for
+// the STS server's debug endpoint.
+func (p *Plugin) DumpPluginStatus() ([]byte, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make(map[string]string, len(p.tokens))
+	for subject, tok := range p.tokens {
+		status[subject] = tok.expiry.String()
+	}
+	out, err := json.MarshalIndent(status, "", "  ")
+	return out, "oidc plugin status", err
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tokenmanager adapts the STS server's generic TokenManager interface
+// to a concrete credential-minting plugin (google, oidc, ...), so the server
+// itself never needs to know which kind of backend a given trust domain uses.
+package tokenmanager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"istio.io/istio/security/pkg/stsservice"
+	"istio.io/istio/security/pkg/stsservice/server"
+)
+
+// PluginType identifies which token exchange plugin a TokenManager delegates to.
+type PluginType int
+
+const (
+	// GoogleTokenExchange delegates to the tokenmanager/google plugin.
+	GoogleTokenExchange PluginType = iota
+	// OIDCTokenExchange delegates to the tokenmanager/oidc plugin.
+	OIDCTokenExchange
+)
+
+// Plugin mints credentials for a subject token. It is implemented by
+// tokenmanager/google.Plugin and tokenmanager/oidc.Plugin.
+type Plugin interface {
+	GetToken(ctx context.Context, parameters stsservice.StsRequestParameters) ([]byte, error)
+	DumpPluginStatus() ([]byte, string, error)
+}
+
+// Config holds the trust domain a TokenManager mints credentials for.
+type Config struct {
+	TrustDomain string
+}
+
+// TokenManager implements server.TokenManager by delegating to a Plugin. The
+// plugin is set after construction via SetPlugin, since plugin construction
+// may itself require the TokenManager's trust domain.
+type TokenManager struct {
+	pluginType PluginType
+	config     Config
+
+	mu     sync.Mutex
+	plugin Plugin
+}
+
+// CreateTokenManager creates a TokenManager for pluginType and config. Callers
+// must call SetPlugin before the first token exchange request arrives.
+func CreateTokenManager(pluginType PluginType, config Config) server.TokenManager {
+	return &TokenManager{
+		pluginType: pluginType,
+		config:     config,
+	}
+}
+
+// SetPlugin installs the plugin this TokenManager delegates to.
+func (tm *TokenManager) SetPlugin(plugin Plugin) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.plugin = plugin
+}
+
+// GenerateToken implements server.TokenManager.
+func (tm *TokenManager) GenerateToken(ctx context.Context, parameters stsservice.StsRequestParameters) ([]byte, error) {
+	tm.mu.Lock()
+	plugin := tm.plugin
+	tm.mu.Unlock()
+	if plugin == nil {
+		return nil, fmt.Errorf("no token exchange plugin configured for trust domain %s", tm.config.TrustDomain)
+	}
+	return plugin.GetToken(ctx, parameters)
+}
+
+// DumpTokenStatus implements server.TokenManager.
+func (tm *TokenManager) DumpTokenStatus() ([]byte, error) {
+	tm.mu.Lock()
+	plugin := tm.plugin
+	tm.mu.Unlock()
+	if plugin == nil {
+		return nil, fmt.Errorf("no token exchange plugin configured for trust domain %s", tm.config.TrustDomain)
+	}
+	out, _, err := plugin.DumpPluginStatus()
+	return out, err
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stsservice
+
+import (
+	"context"
+	"net/http"
+
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+)
+
+// B3Propagation and TraceContextPropagation are the trace-context
+// propagation formats shared by the STS server and its token manager
+// plugins, so a span started for an incoming request can be continued
+// across the federated-token and access-token HTTP hops regardless of which
+// plugin is handling the exchange, and regardless of whether the caller
+// that started the trace uses B3 or W3C (tracecontext) headers.
+var (
+	B3Propagation           = &b3.HTTPFormat{}
+	TraceContextPropagation = &tracecontext.HTTPFormat{}
+)
+
+// InjectTraceHeaders propagates the span carried by ctx onto an outbound
+// request via both B3 and W3C tracecontext headers, so the receiving
+// backend's logs can be correlated with this hop of the exchange regardless
+// of which format it understands.
+func InjectTraceHeaders(ctx context.Context, req *http.Request) {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	B3Propagation.SpanContextToRequest(sc, req)
+	TraceContextPropagation.SpanContextToRequest(sc, req)
+}
+
+// ExtractSpanContext reads a remote span context off an incoming request,
+// trying W3C tracecontext headers first and falling back to B3, so the STS
+// server continues whichever trace the caller started.
+func ExtractSpanContext(req *http.Request) (trace.SpanContext, bool) {
+	if sc, ok := TraceContextPropagation.SpanContextFromRequest(req); ok {
+		return sc, true
+	}
+	return B3Propagation.SpanContextFromRequest(req)
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stsservice defines the request/response types shared between the
+// STS server and its token manager plugins, per RFC 8693 (OAuth 2.0 Token
+// Exchange).
+package stsservice
+
+// StsRequestParameters stores all STS request attributes defined in
+// https://tools.ietf.org/html/rfc8693#section-2.1
+type StsRequestParameters struct {
+	// REQUIRED. The value "urn:ietf:params:oauth:grant-type:token-exchange"
+	// indicates that a token exchange is being performed.
+	GrantType string
+	// OPTIONAL. Indicates the target service or resource where the client
+	// intends to use the requested security token.
+	Resource string
+	// OPTIONAL. The logical name of the target service where the client
+	// intends to use the requested security token.
+	Audience string
+	// OPTIONAL. A list of space-delimited, case-sensitive strings that allow
+	// the client to specify the desired scope of the requested security token.
+	Scope string
+	// OPTIONAL. An identifier, for the type of the requested security token.
+	RequestedTokenType string
+	// REQUIRED. A security token that represents the identity of the party
+	// on behalf of whom the request is being made.
+	SubjectToken string
+	// REQUIRED. An identifier, that indicates the type of the security token
+	// in the subject_token parameter.
+	SubjectTokenType string
+	// OPTIONAL. A security token that represents the identity of the acting
+	// party.
+	ActorToken string
+	// OPTIONAL. An identifier, that indicates the type of the security token
+	// in the actor_token parameter.
+	ActorTokenType string
+}
+
+// StsResponseParameters stores all STS response attributes defined in
+// https://tools.ietf.org/html/rfc8693#section-2.2.1
+type StsResponseParameters struct {
+	// REQUIRED. The security token issued by the authorization server.
+	AccessToken string `json:"access_token"`
+	// REQUIRED. An identifier, that indicates the type of the token.
+	IssuedTokenType string `json:"issued_token_type"`
+	// REQUIRED. A case-insensitive value specifying the method of using the
+	// access token issued, e.g. "Bearer".
+	TokenType string `json:"token_type"`
+	// RECOMMENDED. The validity lifetime, in seconds, of the token issued.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// OPTIONAL, as defined by section 3.3 of [RFC6749]. space-delimited, case
+	// sensitive list of scopes associated with the issued security token.
+	Scope string `json:"scope,omitempty"`
+	// OPTIONAL, if the Authorization Server included a refresh token.
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// StsErrorParameters stores all STS error parameters defined in
+// https://tools.ietf.org/html/rfc8693#section-2.2.2
+type StsErrorParameters struct {
+	// REQUIRED. A single ASCII error code from the set defined in
+	// https://tools.ietf.org/html/rfc6749#section-5.2.
+	Error string `json:"error"`
+	// OPTIONAL. Human-readable text providing additional information.
+	ErrorDescription string `json:"error_description,omitempty"`
+	// OPTIONAL. A URI identifying a human-readable web page with information
+	// about the error.
+	ErrorURI string `json:"error_uri,omitempty"`
+}
+
+// Error codes defined by https://tools.ietf.org/html/rfc6749#section-5.2 and
+// https://tools.ietf.org/html/rfc8693#section-2.2.2.
+const (
+	ErrorInvalidRequest = "invalid_request"
+	ErrorInvalidGrant   = "invalid_grant"
+	ErrorInvalidTarget  = "invalid_target"
+	ErrorServerError    = "server_error"
+)